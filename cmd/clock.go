@@ -0,0 +1,194 @@
+//go:build linux
+// +build linux
+
+// Copyright © 2017 Mesosphere Inc. <http://mesosphere.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Clock abstracts the time and clock-adjustment sources a DCOSChecker reads
+// from, following the pattern of github.com/jmhodges/clock. Checks take a
+// Clock dependency instead of inventing their own syscall-stub field, so
+// table-driven tests can exercise every code path without touching real
+// kernel state.
+type Clock interface {
+	Now() time.Time
+	Since(time.Time) time.Duration
+	Adjtimex(*unix.Timex) (int, error)
+
+	// After returns a channel that receives the current time once d has
+	// elapsed, following time.After. watchTime ticks through this instead
+	// of time.NewTicker so a FakeClock can drive watch-mode deterministically.
+	After(d time.Duration) <-chan time.Time
+}
+
+// New returns a Clock backed by the real system clock.
+func New() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+func (realClock) Adjtimex(tBuf *unix.Timex) (int, error) { return unix.Adjtimex(tBuf) }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// NewFake returns a Clock suitable for deterministic tests. Its Now is
+// fixed until Set or Add is called, and Adjtimex returns whatever
+// SetAdjtimex was last given.
+func NewFake() *FakeClock {
+	return &FakeClock{now: time.Unix(0, 0)}
+}
+
+// FakeClock is a settable Clock for tests.
+type FakeClock struct {
+	mu       sync.Mutex
+	now      time.Time
+	adjtimex func(*unix.Timex) (int, error)
+	sleepers []fakeSleeper
+}
+
+// fakeSleeper is a pending After call waiting for the fake clock to reach
+// until.
+type fakeSleeper struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// Now returns the fake clock's current time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Since returns the fake clock's current time minus t.
+func (c *FakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Set pins the fake clock to t, waking any After calls whose deadline has
+// now passed.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.wakeSleepers()
+	c.mu.Unlock()
+}
+
+// Add advances the fake clock by d, waking any After calls whose deadline
+// has now passed.
+func (c *FakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.wakeSleepers()
+	c.mu.Unlock()
+}
+
+// wakeSleepers fires and removes every sleeper whose deadline is at or
+// before the current fake time. Callers must hold c.mu.
+func (c *FakeClock) wakeSleepers() {
+	remaining := c.sleepers[:0]
+	for _, s := range c.sleepers {
+		if !s.until.After(c.now) {
+			s.ch <- c.now
+			continue
+		}
+		remaining = append(remaining, s)
+	}
+	c.sleepers = remaining
+}
+
+// After returns a channel that receives the fake clock's current time once
+// it has been advanced by at least d via Add or Set.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	until := c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	if !until.After(c.now) {
+		ch <- c.now
+		return ch
+	}
+	c.sleepers = append(c.sleepers, fakeSleeper{until: until, ch: ch})
+	return ch
+}
+
+// BlockUntil blocks until at least n calls to After are pending on the fake
+// clock, so a test can Add/Set without racing the goroutine under test
+// registering its sleeper.
+func (c *FakeClock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		waiting := len(c.sleepers)
+		c.mu.Unlock()
+		if waiting >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// SetAdjtimex installs the function the fake clock's Adjtimex delegates to,
+// letting a test drive any STA_* flag or esterror/offset value.
+func (c *FakeClock) SetAdjtimex(f func(*unix.Timex) (int, error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.adjtimex = f
+}
+
+// Adjtimex delegates to the function installed by SetAdjtimex, or returns a
+// zeroed, successful result if none was installed.
+func (c *FakeClock) Adjtimex(tBuf *unix.Timex) (int, error) {
+	c.mu.Lock()
+	f := c.adjtimex
+	c.mu.Unlock()
+	if f == nil {
+		return 0, nil
+	}
+	return f(tBuf)
+}
+
+// clockContextKey is the context key a Clock is stored under.
+type clockContextKey struct{}
+
+// WithClock returns a copy of ctx carrying clock. TimeCheck.Run and
+// watchTime both check ctx for a Clock before falling back to the check's
+// own Clock field, so a caller (currently only tests, via a direct
+// WithClock call before invoking Run/watchTime) can inject a FakeClock
+// without threading it through the check's constructor. RunCheck itself
+// does not set this up; production call sites run with each check's own
+// Clock field, seeded by New() in NewTimeCheck.
+func WithClock(ctx context.Context, clock Clock) context.Context {
+	return context.WithValue(ctx, clockContextKey{}, clock)
+}
+
+// ClockFromContext returns the Clock stored in ctx by WithClock, if any.
+func ClockFromContext(ctx context.Context) (Clock, bool) {
+	clock, ok := ctx.Value(clockContextKey{}).(Clock)
+	return clock, ok
+}
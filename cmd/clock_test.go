@@ -0,0 +1,89 @@
+//go:build linux
+// +build linux
+
+// Copyright © 2017 Mesosphere Inc. <http://mesosphere.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestFakeClockNowAndSince(t *testing.T) {
+	c := NewFake()
+	start := c.Now()
+
+	c.Add(5 * time.Second)
+	if got := c.Now().Sub(start); got != 5*time.Second {
+		t.Fatalf("Now() advanced by %s, want 5s", got)
+	}
+	if got := c.Since(start); got != 5*time.Second {
+		t.Fatalf("Since(start) = %s, want 5s", got)
+	}
+}
+
+func TestFakeClockAdjtimex(t *testing.T) {
+	c := NewFake()
+	c.SetAdjtimex(func(tBuf *unix.Timex) (int, error) {
+		tBuf.Offset = 42
+		return 0, nil
+	})
+
+	var tBuf unix.Timex
+	if _, err := c.Adjtimex(&tBuf); err != nil {
+		t.Fatalf("Adjtimex returned error: %v", err)
+	}
+	if tBuf.Offset != 42 {
+		t.Fatalf("Offset = %d, want 42", tBuf.Offset)
+	}
+}
+
+func TestFakeClockAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		advance  time.Duration
+		wantFire bool
+	}{
+		{"fires once elapsed", time.Second, time.Second, true},
+		{"fires once exceeded", time.Second, 2 * time.Second, true},
+		{"does not fire early", time.Second, 500 * time.Millisecond, false},
+		{"zero interval fires immediately", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewFake()
+			ch := c.After(tt.interval)
+			if tt.advance > 0 {
+				c.Add(tt.advance)
+			}
+
+			select {
+			case <-ch:
+				if !tt.wantFire {
+					t.Fatalf("After(%s) fired after advancing %s, want no fire", tt.interval, tt.advance)
+				}
+			default:
+				if tt.wantFire {
+					t.Fatalf("After(%s) did not fire after advancing %s, want fire", tt.interval, tt.advance)
+				}
+			}
+		})
+	}
+}
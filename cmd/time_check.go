@@ -1,4 +1,6 @@
+//go:build linux
 // +build linux
+
 // Copyright © 2017 Mesosphere Inc. <http://mesosphere.com>
 //
 // Licensed under the Apache License, Version 2.0 (the "License");
@@ -17,20 +19,219 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
 )
 
 const (
+	// STA_PLL is taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/timex.h
+	staPLL = 0x0001
+	// STA_PPSFREQ is taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/timex.h
+	staPPSFreq = 0x0002
+	// STA_PPSTIME is taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/timex.h
+	staPPSTime = 0x0004
+	// STA_FLL is taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/timex.h
+	staFLL = 0x0008
+	// STA_INS is taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/timex.h
+	staIns = 0x0010
+	// STA_DEL is taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/timex.h
+	staDel = 0x0020
 	// STA_UNSYNC is taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/timex.h#L137
 	staUnsync = 0x0040
+	// STA_FREQHOLD is taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/timex.h
+	staFreqHold = 0x0080
+	// STA_PPSSIGNAL is taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/timex.h
+	staPPSSignal = 0x0100
+	// STA_PPSJITTER is taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/timex.h
+	staPPSJitter = 0x0200
+	// STA_PPSWANDER is taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/timex.h
+	staPPSWander = 0x0400
+	// STA_PPSERROR is taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/timex.h
+	staPPSError = 0x0800
+	// STA_CLOCKERR is taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/timex.h
+	staClockErr = 0x1000
+	// STA_NANO is taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/timex.h
+	staNano = 0x2000
+	// STA_MODE is taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/timex.h
+	staMode = 0x4000
+	// STA_CLK is taken from https://github.com/torvalds/linux/blob/master/include/uapi/linux/timex.h
+	staClk = 0x8000
+
+	// defaultMaxEsterror preserves the check's historical 100 millisecond
+	// estimated-error threshold.
+	defaultMaxEsterror = 100 * time.Millisecond
+
+	// timexReportVersion is the schema version of TimexReport. Bump it
+	// whenever a field is added, removed, or changes meaning.
+	timexReportVersion = 1
 
-	// 100 millisecond
-	maxEstErrorUs = int64(time.Microsecond * 100000)
+	outputText = "text"
+	outputJSON = "json"
+
+	clockRealtime = "realtime"
+	clockTAI      = "tai"
+)
+
+// ClockID identifies the clock a TimeCheck queries, as understood by
+// clock_adjtime(2).
+type ClockID int32
+
+// Thresholds holds the limits a TimeCheck sample is evaluated against. A
+// zero value disables the corresponding check.
+type Thresholds struct {
+	MaxOffset   time.Duration
+	MaxEsterror time.Duration
+	MaxMaxerror time.Duration
+}
+
+// defaultThresholds preserves the check's historical behavior: only the
+// estimated-error threshold is enforced.
+var defaultThresholds = Thresholds{MaxEsterror: defaultMaxEsterror}
+
+// staFlagNames maps each STA_* bit to the name it is decoded as.
+var staFlagNames = []struct {
+	bit  int32
+	name string
+}{
+	{staPLL, "STA_PLL"},
+	{staPPSFreq, "STA_PPSFREQ"},
+	{staPPSTime, "STA_PPSTIME"},
+	{staFLL, "STA_FLL"},
+	{staIns, "STA_INS"},
+	{staDel, "STA_DEL"},
+	{staUnsync, "STA_UNSYNC"},
+	{staFreqHold, "STA_FREQHOLD"},
+	{staPPSSignal, "STA_PPSSIGNAL"},
+	{staPPSJitter, "STA_PPSJITTER"},
+	{staPPSWander, "STA_PPSWANDER"},
+	{staPPSError, "STA_PPSERROR"},
+	{staClockErr, "STA_CLOCKERR"},
+	{staNano, "STA_NANO"},
+	{staMode, "STA_MODE"},
+	{staClk, "STA_CLK"},
+}
+
+// DecodeTimexStatus decodes the STA_* bits set in a unix.Timex.Status value
+// into their symbolic names, so callers don't have to duplicate the
+// bitmask table from linux/timex.h.
+func DecodeTimexStatus(status int32) []string {
+	var flags []string
+	for _, f := range staFlagNames {
+		if status&f.bit > 0 {
+			flags = append(flags, f.name)
+		}
+	}
+	return flags
+}
+
+// TimexReport is a structured, versioned snapshot of the kernel's timex
+// state, suitable for feeding into dashboards and alerting.
+type TimexReport struct {
+	Version int `json:"version"`
+
+	OffsetUs   int64 `json:"offset_us"`
+	EsterrorUs int64 `json:"esterror_us"`
+	MaxerrorUs int64 `json:"maxerror_us"`
+	FreqPPM    int64 `json:"freq_ppm"`
+	TickUs     int64 `json:"tick_us"`
+
+	PPSFreq      int64 `json:"pps_freq"`
+	PPSJitter    int64 `json:"pps_jitter"`
+	PPSStability int64 `json:"pps_stability"`
+
+	TAI int32 `json:"tai"`
+
+	StatusFlags []string `json:"status_flags"`
+	Synced      bool     `json:"synced"`
+}
+
+// timexUnit returns the unit that the offset/esterror/maxerror fields of a
+// struct timex are reported in: nanoseconds if STA_NANO is set in status
+// (as chrony and ptp4l do), microseconds otherwise. See adjtimex(2).
+func timexUnit(status int32) time.Duration {
+	if status&staNano != 0 {
+		return time.Nanosecond
+	}
+	return time.Microsecond
+}
+
+// newTimexReport builds a TimexReport from a raw unix.Timex buffer.
+func newTimexReport(tBuf *unix.Timex) TimexReport {
+	flags := DecodeTimexStatus(tBuf.Status)
+	unit := timexUnit(tBuf.Status)
+	return TimexReport{
+		Version: timexReportVersion,
+
+		OffsetUs:   int64(time.Duration(tBuf.Offset) * unit / time.Microsecond),
+		EsterrorUs: int64(time.Duration(tBuf.Esterror) * unit / time.Microsecond),
+		MaxerrorUs: int64(time.Duration(tBuf.Maxerror) * unit / time.Microsecond),
+		FreqPPM:    int64(tBuf.Freq),
+		TickUs:     int64(tBuf.Tick),
+
+		PPSFreq:      int64(tBuf.Ppsfreq),
+		PPSJitter:    int64(tBuf.Jitter),
+		PPSStability: int64(tBuf.Stabil),
+
+		TAI: tBuf.Tai,
+
+		StatusFlags: flags,
+		Synced:      tBuf.Status&staUnsync == 0,
+	}
+}
+
+// fdToClockID converts an open PTP character device's file descriptor into
+// the dynamic clockid_t the kernel expects, per the FD_TO_CLOCKID macro in
+// linux/ptp_clock.h: ((~fd) << 3) | 3.
+func fdToClockID(fd uintptr) ClockID {
+	return ClockID((^int32(fd) << 3) | 3)
+}
+
+// resolveClock maps a --clock flag value to a ClockID. "realtime" (the
+// default) and "tai" resolve to their respective well-known clocks;
+// anything else is treated as a PTP character device path (e.g. /dev/ptp0)
+// and opened so its file descriptor can be folded into a dynamic clock ID.
+// If a device was opened, the caller owns closing it.
+//
+// CLOCK_MONOTONIC is deliberately not offered here: clock_adjtime(2) only
+// supports CLOCK_REALTIME, CLOCK_TAI, and dynamic PTP clocks, so querying it
+// this way would reliably fail with EINVAL.
+func resolveClock(clock string) (ClockID, *os.File, error) {
+	switch clock {
+	case "", clockRealtime:
+		return ClockID(unix.CLOCK_REALTIME), nil, nil
+	case clockTAI:
+		return ClockID(unix.CLOCK_TAI), nil, nil
+	default:
+		dev, err := os.Open(clock)
+		if err != nil {
+			return 0, nil, errors.Wrapf(err, "unable to open PTP clock device %s", clock)
+		}
+		return fdToClockID(dev.Fd()), dev, nil
+	}
+}
+
+// timeOutputFormat backs the --output flag on timeCmd.
+var timeOutputFormat string
+
+// timeClock backs the --clock flag on timeCmd.
+var timeClock string
+
+// Flags backing timeCmd's --watch mode.
+var (
+	timeWatch               bool
+	timeInterval            time.Duration
+	timeMaxOffset           time.Duration
+	timeMaxEsterror         time.Duration
+	timeMaxMaxerror         time.Duration
+	timeConsecutiveFailures int
 )
 
 // timeCmd represents the time command
@@ -39,15 +240,114 @@ var timeCmd = &cobra.Command{
 	Short: "Verify time is synced",
 	Long:  `This check uses a system call adjtimex to validate time is synced.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		RunCheck(context.TODO(), NewTimeCheck("Check clock synchronization"))
+		clockID, dev, err := resolveClock(timeClock)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(statusUnknown)
+		}
+		if dev != nil {
+			defer dev.Close()
+		}
+
+		tc := NewTimeCheck("Check clock synchronization", clockID).(*TimeCheck)
+		tc.OutputFormat = timeOutputFormat
+		if timeMaxOffset > 0 {
+			tc.Thresholds.MaxOffset = timeMaxOffset
+		}
+		if cmd.Flags().Changed("max-esterror") {
+			tc.Thresholds.MaxEsterror = timeMaxEsterror
+		}
+		if timeMaxMaxerror > 0 {
+			tc.Thresholds.MaxMaxerror = timeMaxMaxerror
+		}
+
+		if timeWatch {
+			ctx, cancel := context.WithCancel(context.Background())
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-sigCh
+				cancel()
+			}()
+
+			os.Exit(watchTime(ctx, tc, timeInterval, timeConsecutiveFailures))
+		}
+
+		RunCheck(context.TODO(), tc)
 	},
 }
 
+// watchTime runs tc on an interval, streaming each sample to stdout as
+// either a tabular line (text output) or a JSON-lines stream (json output),
+// until ctx is done or the check has failed consecutiveFailures times in a
+// row. It ticks through tc's Clock (a FakeClock in tests, the real clock in
+// production) rather than time.NewTicker, so watch-mode timing is
+// deterministically testable.
+func watchTime(ctx context.Context, tc *TimeCheck, interval time.Duration, consecutiveFailures int) int {
+	clock := tc.Clock
+	if c, ok := ClockFromContext(ctx); ok {
+		clock = c
+	}
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return statusOK
+		case <-clock.After(interval):
+			report, status, err := tc.sample(ctx)
+			var msg string
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			} else {
+				msg = formatWatchSample(tc.OutputFormat, report)
+			}
+			fmt.Println(msg)
+
+			if status != statusOK {
+				failures++
+			} else {
+				failures = 0
+			}
+
+			if failures >= consecutiveFailures {
+				return status
+			}
+		}
+	}
+}
+
+// formatWatchSample renders one TimexReport as a --watch line: a JSON-lines
+// record when outputFormat is "json", or a tabular line of its raw
+// offset/esterror/maxerror/status values otherwise. Unlike Run's single-shot
+// text output, this always shows the sample's actual readings rather than a
+// one-line synced/violation sentence.
+func formatWatchSample(outputFormat string, report TimexReport) string {
+	if outputFormat == outputJSON {
+		b, err := json.Marshal(report)
+		if err != nil {
+			return fmt.Sprintf("unable to marshal timex report: %v", err)
+		}
+		return string(b)
+	}
+
+	return fmt.Sprintf(
+		"offset=%s esterror=%s maxerror=%s status=%v",
+		time.Duration(report.OffsetUs)*time.Microsecond,
+		time.Duration(report.EsterrorUs)*time.Microsecond,
+		time.Duration(report.MaxerrorUs)*time.Microsecond,
+		report.StatusFlags,
+	)
+}
+
 // NewTimeCheck returns a new initialized instance of TimeCheck.
-func NewTimeCheck(name string) DCOSChecker {
+func NewTimeCheck(name string, clockID ClockID) DCOSChecker {
 	return &TimeCheck{
-		Name:        name,
-		runAdjtimex: syscall.Adjtimex,
+		Name:         name,
+		OutputFormat: outputText,
+		ClockID:      clockID,
+		Thresholds:   defaultThresholds,
+		Clock:        New(),
 	}
 }
 
@@ -55,7 +355,26 @@ func NewTimeCheck(name string) DCOSChecker {
 type TimeCheck struct {
 	Name string
 
-	runAdjtimex func(*syscall.Timex) (int, error)
+	// OutputFormat selects between a human-readable summary ("text", the
+	// default) and a structured report ("json").
+	OutputFormat string
+
+	// ClockID selects which clock is queried. It defaults to
+	// CLOCK_REALTIME; any other value (e.g. a PTP hardware clock resolved
+	// from a /dev/ptpN device) is queried via clock_adjtime(2) instead of
+	// adjtimex(2).
+	ClockID ClockID
+
+	// Thresholds are the limits samples from this check are evaluated
+	// against, independent of how they were configured on the CLI.
+	Thresholds Thresholds
+
+	// Clock is the time and clock-adjustment source this check reads from,
+	// defaulted to New() by NewTimeCheck. A Clock embedded in the ctx passed
+	// to Run or watchTime (see WithClock) takes precedence over this field
+	// without mutating the check itself; tests use this to inject a
+	// FakeClock. RunCheck does not do this wiring itself.
+	Clock Clock
 }
 
 // ID returns a check ID.
@@ -63,32 +382,106 @@ func (t *TimeCheck) ID() string {
 	return t.Name
 }
 
-// Run executes the check.
-func (t *TimeCheck) Run(ctx context.Context, cfg *CLIConfigFlags) (string, int, error) {
-	tBuf := syscall.Timex{}
+// sample takes one reading from t's clock/ClockID and evaluates it against
+// t.Thresholds, returning the structured report alongside the resulting
+// check status. Run and watchTime both build on this so a single syscall
+// path backs every output format.
+func (t *TimeCheck) sample(ctx context.Context) (TimexReport, int, error) {
+	clock := t.Clock
+	if c, ok := ClockFromContext(ctx); ok {
+		clock = c
+	}
+
+	tBuf := unix.Timex{}
 
 	// intentionally ignore status. If err != nil, status != 0
-	_, err := t.runAdjtimex(&tBuf)
+	var err error
+	if t.ClockID == ClockID(unix.CLOCK_REALTIME) {
+		_, err = clock.Adjtimex(&tBuf)
+	} else {
+		_, err = unix.ClockAdjtime(int32(t.ClockID), &tBuf)
+	}
+	if err != nil {
+		return TimexReport{}, statusUnknown, errors.Wrap(err, "unable to make a system call adjtimex")
+	}
+
+	report := newTimexReport(&tBuf)
+	status := statusOK
+	if !report.Synced || t.thresholdsExceeded(&tBuf) {
+		status = statusFailure
+	}
+	return report, status, nil
+}
+
+// Run executes the check.
+func (t *TimeCheck) Run(ctx context.Context, cfg *CLIConfigFlags) (string, int, error) {
+	report, status, err := t.sample(ctx)
 	if err != nil {
-		return "", statusUnknown, errors.Wrap(err, "unable to make a system call adjtimex")
+		return "", status, err
+	}
+
+	if t.OutputFormat == outputJSON {
+		b, err := json.Marshal(report)
+		if err != nil {
+			return "", statusUnknown, errors.Wrap(err, "unable to marshal timex report")
+		}
+		return string(b), status, nil
 	}
 
 	// This is to check if NTP thinks the clock is unstable
-	if diff := int64(tBuf.Esterror) - maxEstErrorUs; diff > 0 {
-		return fmt.Sprintf("Clock is less stable than allowed. Max estimated error exceeded by: %s", time.Duration(diff)*time.Microsecond), statusFailure, nil
+	if max := t.Thresholds.MaxEsterror; max > 0 {
+		if diff := time.Duration(report.EsterrorUs)*time.Microsecond - max; diff > 0 {
+			return fmt.Sprintf("Clock is less stable than allowed. Max estimated error exceeded by: %s", diff), statusFailure, nil
+		}
+	}
+
+	if max := t.Thresholds.MaxOffset; max > 0 {
+		if diff := time.Duration(report.OffsetUs)*time.Microsecond - max; diff > 0 {
+			return fmt.Sprintf("Clock offset exceeds allowed maximum by: %s", diff), statusFailure, nil
+		}
+	}
+
+	if max := t.Thresholds.MaxMaxerror; max > 0 {
+		if diff := time.Duration(report.MaxerrorUs)*time.Microsecond - max; diff > 0 {
+			return fmt.Sprintf("Clock max error exceeds allowed maximum by: %s", diff), statusFailure, nil
+		}
 	}
 
 	// If NTP is down for ~16000 seconds, the clock will go unsync, based on
 	// modern kernels. Unfortunately, even though there are a bunch of other
 	// heuristics in the timex struct, it doesn't make a ton of sense to look
 	// at them. Maybe in the future we can do something smarter.
-	if (tBuf.Status & staUnsync) > 0 {
+	if !report.Synced {
 		return "Clock is out of sync / in unsync state. Must be synchronized for proper operation.", statusFailure, nil
 	}
 
 	return "Clock is synced", statusOK, nil
 }
 
+// thresholdsExceeded reports whether any configured threshold is violated
+// by tBuf, regardless of output format.
+func (t *TimeCheck) thresholdsExceeded(tBuf *unix.Timex) bool {
+	unit := timexUnit(tBuf.Status)
+	if max := t.Thresholds.MaxEsterror; max > 0 && time.Duration(tBuf.Esterror)*unit > max {
+		return true
+	}
+	if max := t.Thresholds.MaxOffset; max > 0 && time.Duration(tBuf.Offset)*unit > max {
+		return true
+	}
+	if max := t.Thresholds.MaxMaxerror; max > 0 && time.Duration(tBuf.Maxerror)*unit > max {
+		return true
+	}
+	return false
+}
+
 func init() {
+	timeCmd.Flags().StringVar(&timeOutputFormat, "output", outputText, "Output format: text or json")
+	timeCmd.Flags().StringVar(&timeClock, "clock", clockRealtime, "Clock to check: realtime, tai, or a PTP device path (e.g. /dev/ptp0)")
+	timeCmd.Flags().BoolVar(&timeWatch, "watch", false, "Continuously check clock synchronization on an interval")
+	timeCmd.Flags().DurationVar(&timeInterval, "interval", time.Second, "Sampling interval when --watch is set")
+	timeCmd.Flags().DurationVar(&timeMaxOffset, "max-offset", 0, "Maximum allowed clock offset, 0 disables the check")
+	timeCmd.Flags().DurationVar(&timeMaxEsterror, "max-esterror", defaultMaxEsterror, "Maximum allowed estimated error, 0 disables the check")
+	timeCmd.Flags().DurationVar(&timeMaxMaxerror, "max-maxerror", 0, "Maximum allowed maximum error, 0 disables the check")
+	timeCmd.Flags().IntVar(&timeConsecutiveFailures, "consecutive-failures", 1, "Number of consecutive failed samples (threshold violations, unsynced clock, or read errors) required to fail when --watch is set")
 	RootCmd.AddCommand(timeCmd)
 }
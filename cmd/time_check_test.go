@@ -0,0 +1,153 @@
+//go:build linux
+// +build linux
+
+// Copyright © 2017 Mesosphere Inc. <http://mesosphere.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestThresholdsExceededUnits(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int32
+		esterror    int64
+		maxEsterror time.Duration
+		wantExceed  bool
+	}{
+		{"microsecond units under threshold", 0, 99_000, 100 * time.Millisecond, false},
+		{"microsecond units over threshold", 0, 101_000, 100 * time.Millisecond, true},
+		{"nano units under threshold not exceeded at raw value", staNano, 99_000_000, 100 * time.Millisecond, false},
+		{"nano units over threshold", staNano, 101_000_000, 100 * time.Millisecond, true},
+		{"nano units well over threshold", staNano, 150_000_000, 100 * time.Millisecond, true},
+		{"esterror boundary exactly at threshold does not exceed", 0, 100_000, 100 * time.Millisecond, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &TimeCheck{Thresholds: Thresholds{MaxEsterror: tt.maxEsterror}}
+			tBuf := &unix.Timex{Status: tt.status, Esterror: tt.esterror}
+			if got := tc.thresholdsExceeded(tBuf); got != tt.wantExceed {
+				t.Fatalf("thresholdsExceeded() = %v, want %v", got, tt.wantExceed)
+			}
+		})
+	}
+}
+
+func TestNewTimexReportScalesByStaNano(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int32
+		offset     int64
+		wantOffset int64
+	}{
+		{"microsecond units reported as-is", 0, 50, 50},
+		{"nano units converted down to microseconds", staNano, 50_000, 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := newTimexReport(&unix.Timex{Status: tt.status, Offset: tt.offset})
+			if report.OffsetUs != tt.wantOffset {
+				t.Fatalf("OffsetUs = %d, want %d", report.OffsetUs, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestFormatWatchSampleShowsRealReadings(t *testing.T) {
+	report := newTimexReport(&unix.Timex{Offset: 50, Esterror: 1_000, Maxerror: 750_000, Status: staPLL})
+
+	text := formatWatchSample(outputText, report)
+	wantSubstrings := []string{"offset=50µs", "esterror=1ms", "maxerror=750ms", "STA_PLL"}
+	for _, want := range wantSubstrings {
+		if !strings.Contains(text, want) {
+			t.Fatalf("formatWatchSample(text) = %q, want substring %q", text, want)
+		}
+	}
+
+	jsonLine := formatWatchSample(outputJSON, report)
+	var decoded TimexReport
+	if err := json.Unmarshal([]byte(jsonLine), &decoded); err != nil {
+		t.Fatalf("formatWatchSample(json) produced invalid JSON: %v", err)
+	}
+	if decoded.OffsetUs != report.OffsetUs {
+		t.Fatalf("decoded OffsetUs = %d, want %d", decoded.OffsetUs, report.OffsetUs)
+	}
+}
+
+func TestWatchTimeStopsOnContextCancel(t *testing.T) {
+	clock := NewFake()
+	clock.SetAdjtimex(func(tBuf *unix.Timex) (int, error) { return 0, nil })
+
+	tc := NewTimeCheck("watch", ClockID(unix.CLOCK_REALTIME)).(*TimeCheck)
+	tc.Clock = clock
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = WithClock(ctx, clock)
+
+	done := make(chan int, 1)
+	go func() { done <- watchTime(ctx, tc, time.Second, 1) }()
+
+	clock.BlockUntil(1)
+	cancel()
+
+	select {
+	case status := <-done:
+		if status != statusOK {
+			t.Fatalf("watchTime returned %d after cancel, want statusOK", status)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("watchTime did not return after ctx was canceled")
+	}
+}
+
+func TestWatchTimeFailsAfterConsecutiveFailures(t *testing.T) {
+	clock := NewFake()
+	clock.SetAdjtimex(func(tBuf *unix.Timex) (int, error) {
+		tBuf.Status = staUnsync
+		return 0, nil
+	})
+
+	tc := NewTimeCheck("watch", ClockID(unix.CLOCK_REALTIME)).(*TimeCheck)
+	tc.Clock = clock
+
+	ctx := WithClock(context.Background(), clock)
+
+	done := make(chan int, 1)
+	go func() { done <- watchTime(ctx, tc, time.Second, 3) }()
+
+	for i := 0; i < 3; i++ {
+		clock.BlockUntil(1)
+		clock.Add(time.Second)
+	}
+
+	select {
+	case status := <-done:
+		if status != statusFailure {
+			t.Fatalf("watchTime returned %d, want statusFailure", status)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("watchTime did not return after 3 consecutive failures")
+	}
+}
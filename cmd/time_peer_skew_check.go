@@ -0,0 +1,252 @@
+//go:build linux
+// +build linux
+
+// Copyright © 2017 Mesosphere Inc. <http://mesosphere.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// ntpEpochOffset is the number of seconds between the NTP epoch
+	// (1900-01-01) and the Unix epoch (1970-01-01).
+	ntpEpochOffset = 2208988800
+
+	sntpPort           = "123"
+	defaultPeerTimeout = 2 * time.Second
+	defaultMaxPeerSkew = 500 * time.Millisecond
+)
+
+// peerMaxSkew backs the --max-peer-skew flag.
+var peerMaxSkew time.Duration
+
+// timePeerSkewCmd represents the time-peer-skew command
+var timePeerSkewCmd = &cobra.Command{
+	Use:   "time-peer-skew",
+	Short: "Verify clock agrees with cluster peers",
+	Long: `This check compares the local clock against a list of peer endpoints
+(other DC/OS masters/agents) to catch split-brain scenarios where the local
+NTP daemon is healthy but pointed at a wrong upstream, which the local-only
+time check cannot see.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		tpsc := NewTimePeerSkewCheck("Check clock skew against cluster peers").(*TimePeerSkewCheck)
+		tpsc.MaxSkew = peerMaxSkew
+		RunCheck(context.TODO(), tpsc)
+	},
+}
+
+// NewTimePeerSkewCheck returns a new initialized instance of
+// TimePeerSkewCheck.
+func NewTimePeerSkewCheck(name string) DCOSChecker {
+	return &TimePeerSkewCheck{
+		Name:      name,
+		MaxSkew:   defaultMaxPeerSkew,
+		probePeer: probePeer,
+	}
+}
+
+// TimePeerSkewCheck compares the local clock against a set of cluster peers,
+// failing if any of them disagree with it by more than MaxSkew.
+type TimePeerSkewCheck struct {
+	Name string
+
+	// MaxSkew is the maximum offset, in either direction, tolerated between
+	// the local clock and any peer.
+	MaxSkew time.Duration
+
+	probePeer func(peer string, timeout time.Duration) (time.Duration, error)
+}
+
+// ID returns a check ID.
+func (t *TimePeerSkewCheck) ID() string {
+	return t.Name
+}
+
+// Run executes the check.
+func (t *TimePeerSkewCheck) Run(ctx context.Context, cfg *CLIConfigFlags) (string, int, error) {
+	peers := peersFromConfig(cfg)
+	if len(peers) == 0 {
+		return "No peers configured to compare clock skew against", statusUnknown, nil
+	}
+
+	responded := 0
+	var worst time.Duration
+	var worstPeer string
+
+	for _, peer := range peers {
+		offset, err := t.probePeer(peer, defaultPeerTimeout)
+		if err != nil {
+			continue
+		}
+		responded++
+
+		if absDuration(offset) > absDuration(worst) {
+			worst = offset
+			worstPeer = peer
+		}
+	}
+
+	if !hasQuorum(responded, len(peers)) {
+		return fmt.Sprintf("Only %d/%d peers responded, below quorum of %d", responded, len(peers), quorum(len(peers))), statusUnknown, nil
+	}
+
+	if absDuration(worst) > t.MaxSkew {
+		return fmt.Sprintf("Clock skew against peer %s exceeds allowed maximum of %s: %s", worstPeer, t.MaxSkew, worst), statusFailure, nil
+	}
+
+	return "Clock is within allowed skew of all responding peers", statusOK, nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// quorum returns the minimum number of peers that must respond out of total
+// for a skew measurement to be trusted: a strict majority.
+func quorum(total int) int {
+	return total/2 + 1
+}
+
+// hasQuorum reports whether responded peers out of total meets quorum.
+func hasQuorum(responded, total int) bool {
+	return responded >= quorum(total)
+}
+
+// peersFromConfig discovers the peer list to compare the clock against,
+// reusing the same master list CLIConfigFlags exposes to other checks.
+func peersFromConfig(cfg *CLIConfigFlags) []string {
+	if cfg == nil {
+		return nil
+	}
+	return cfg.MasterList
+}
+
+// probePeer computes the round-trip-compensated offset between the local
+// clock and peer. It prefers SNTP (RFC 4330) and falls back to an HTTP Date
+// header probe for peers that don't answer NTP requests.
+func probePeer(peer string, timeout time.Duration) (time.Duration, error) {
+	if offset, err := sntpOffset(peer, timeout); err == nil {
+		return offset, nil
+	}
+	return httpDateOffset(peer, timeout)
+}
+
+// sntpOffset implements a minimal RFC 4330 SNTP client: send a client
+// request packet, read the server's reply, and compute the offset as
+// ((T2-T1)+(T3-T4))/2.
+func sntpOffset(peer string, timeout time.Duration) (time.Duration, error) {
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(peer, sntpPort), timeout)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to dial peer over NTP")
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, errors.Wrap(err, "unable to set NTP probe deadline")
+	}
+
+	req := make([]byte, 48)
+	req[0] = 0x1B // LI=0, VN=3, Mode=3 (client)
+
+	t1 := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, errors.Wrap(err, "unable to send NTP request")
+	}
+
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to read NTP response")
+	}
+	t4 := time.Now()
+
+	if err := validateSntpResponse(resp, n); err != nil {
+		return 0, errors.Wrapf(err, "invalid NTP response from %s", peer)
+	}
+
+	t2 := ntpTimeFromBytes(resp[32:40])
+	t3 := ntpTimeFromBytes(resp[40:48])
+
+	return (t2.Sub(t1) + t3.Sub(t4)) / 2, nil
+}
+
+// validateSntpResponse checks that an SNTP reply is long enough to contain
+// all three reply timestamps and was sent in server or broadcast mode,
+// before its bytes are trusted as a real NTP response.
+func validateSntpResponse(resp []byte, n int) error {
+	if n < 48 {
+		return errors.Errorf("response was truncated: got %d bytes, want 48", n)
+	}
+	if mode := resp[0] & 0x07; mode != 4 && mode != 5 {
+		return errors.Errorf("unexpected mode %d, want server(4) or broadcast(5)", mode)
+	}
+	return nil
+}
+
+// ntpTimeFromBytes decodes an NTP timestamp (32-bit seconds since 1900
+// followed by a 32-bit fraction) into a time.Time.
+func ntpTimeFromBytes(b []byte) time.Time {
+	seconds := binary.BigEndian.Uint32(b[0:4])
+	fraction := binary.BigEndian.Uint32(b[4:8])
+
+	secs := int64(seconds) - ntpEpochOffset
+	nanos := int64(float64(fraction) / (1 << 32) * float64(time.Second))
+	return time.Unix(secs, nanos)
+}
+
+// httpDateOffset estimates clock skew from a peer's HTTP Date response
+// header, for peers that don't speak NTP.
+func httpDateOffset(peer string, timeout time.Duration) (time.Duration, error) {
+	client := http.Client{Timeout: timeout}
+
+	t1 := time.Now()
+	resp, err := client.Head(fmt.Sprintf("http://%s/", peer))
+	t2 := time.Now()
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to probe peer over HTTP")
+	}
+	defer resp.Body.Close()
+
+	dateHeader := resp.Header.Get("Date")
+	if dateHeader == "" {
+		return 0, errors.New("peer response missing Date header")
+	}
+
+	peerTime, err := http.ParseTime(dateHeader)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to parse peer Date header")
+	}
+
+	midpoint := t1.Add(t2.Sub(t1) / 2)
+	return peerTime.Sub(midpoint), nil
+}
+
+func init() {
+	timePeerSkewCmd.Flags().DurationVar(&peerMaxSkew, "max-peer-skew", defaultMaxPeerSkew, "Maximum allowed clock skew against any peer")
+	RootCmd.AddCommand(timePeerSkewCmd)
+}
@@ -0,0 +1,133 @@
+//go:build linux
+// +build linux
+
+// Copyright © 2017 Mesosphere Inc. <http://mesosphere.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// ntpBytesFromTime is the inverse of ntpTimeFromBytes, kept test-local since
+// production code only ever decodes timestamps a peer sent over the wire.
+func ntpBytesFromTime(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b[0:4], uint32(t.Unix()+ntpEpochOffset))
+	binary.BigEndian.PutUint32(b[4:8], uint32(float64(t.Nanosecond())/float64(time.Second)*(1<<32)))
+	return b
+}
+
+func TestNtpTimeFromBytesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		want time.Time
+	}{
+		{"unix epoch", time.Unix(0, 0)},
+		{"ntp epoch", time.Unix(-ntpEpochOffset, 0)},
+		{"recent time with sub-second fraction", time.Unix(1_700_000_000, 500_000_000)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ntpTimeFromBytes(ntpBytesFromTime(tt.want))
+			// The fractional second is quantized to 1/2^32s, so allow a
+			// small tolerance rather than requiring bit-exact equality.
+			if diff := got.Sub(tt.want); diff > time.Microsecond || diff < -time.Microsecond {
+				t.Fatalf("ntpTimeFromBytes round trip = %v, want %v (diff %s)", got, tt.want, diff)
+			}
+		})
+	}
+}
+
+func TestValidateSntpResponse(t *testing.T) {
+	serverReply := make([]byte, 48)
+	serverReply[0] = 0x1C // LI=0, VN=3, Mode=4 (server)
+
+	broadcastReply := make([]byte, 48)
+	broadcastReply[0] = 0x1D // LI=0, VN=3, Mode=5 (broadcast)
+
+	clientReply := make([]byte, 48)
+	clientReply[0] = 0x1B // Mode=3 (client) -- not a valid server reply
+
+	tests := []struct {
+		name    string
+		resp    []byte
+		n       int
+		wantErr bool
+	}{
+		{"full server reply is valid", serverReply, 48, false},
+		{"full broadcast reply is valid", broadcastReply, 48, false},
+		{"truncated reply is rejected", serverReply, 32, true},
+		{"zero-byte reply is rejected", serverReply, 0, true},
+		{"client-mode reply is rejected", clientReply, 48, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSntpResponse(tt.resp, tt.n)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateSntpResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHasQuorum(t *testing.T) {
+	tests := []struct {
+		name      string
+		responded int
+		total     int
+		want      bool
+	}{
+		{"1 of 1 responded", 1, 1, true},
+		{"0 of 1 responded", 0, 1, false},
+		{"1 of 2 responded is not a majority", 1, 2, false},
+		{"2 of 2 responded", 2, 2, true},
+		{"1 of 3 responded is below quorum", 1, 3, false},
+		{"2 of 3 responded meets quorum", 2, 3, true},
+		{"3 of 3 responded", 3, 3, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasQuorum(tt.responded, tt.total); got != tt.want {
+				t.Fatalf("hasQuorum(%d, %d) = %v, want %v", tt.responded, tt.total, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAbsDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		in   time.Duration
+		want time.Duration
+	}{
+		{"positive", 5 * time.Second, 5 * time.Second},
+		{"negative", -5 * time.Second, 5 * time.Second},
+		{"zero", 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := absDuration(tt.in); got != tt.want {
+				t.Fatalf("absDuration(%s) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}